@@ -0,0 +1,57 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"sync"
+)
+
+// harvesterCommonAttributes is embedded in the Harvester so every outgoing
+// batch (metrics, spans, logs, events) can carry the same identity tags,
+// such as service.name, host, region, datacenter, and node_id, without
+// copying them onto every Attributes map by hand. It is safe to read and
+// replace concurrently with harvesting. This is distinct from the
+// commonAttributes type in metrics.go, which is a metricBatch view used
+// only for JSON rendering.
+//
+// Attributes are stored as a plain map, not pre-marshaled JSON: Reporter's
+// Report method already takes commonAttributes as a map, and keeping a
+// single representation means the NR and InfluxDB reporters (and any
+// future one) serialize it the same way they serialize everything else.
+type harvesterCommonAttributes struct {
+	mu         sync.RWMutex
+	attributes map[string]interface{}
+}
+
+// ConfigCommonAttributes sets attributes that are merged into the common
+// block of every metric, span, log, and event batch the Harvester sends,
+// in addition to any attributes set on an individual data point. Per-metric
+// Attributes take precedence over a CommonAttribute of the same name.
+func ConfigCommonAttributes(attributes map[string]interface{}) HarvestOption {
+	return func(h *Harvester) {
+		h.SetCommonAttributes(attributes)
+	}
+}
+
+// SetCommonAttributes replaces the Harvester's common attributes. The new
+// attributes take effect starting with the next harvest. They are stored
+// as-is rather than marshaled to JSON, so setting them cannot fail; each
+// Reporter marshals
+// them (if it needs to) when it builds its own wire format, and any
+// failure there surfaces through that Reporter's Report error instead.
+func (h *Harvester) SetCommonAttributes(attributes map[string]interface{}) {
+	h.commonAttrs.mu.Lock()
+	defer h.commonAttrs.mu.Unlock()
+	h.commonAttrs.attributes = attributes
+}
+
+// CommonAttributes returns the Harvester's current common attributes.
+// Reporters that are bound to a Harvester (such as the default NR
+// reporter) call this to merge the Harvester-wide attributes into the
+// commonAttributes they're given for a particular Report call.
+func (h *Harvester) CommonAttributes() map[string]interface{} {
+	h.commonAttrs.mu.RLock()
+	defer h.commonAttrs.mu.RUnlock()
+	return h.commonAttrs.attributes
+}
@@ -0,0 +1,254 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/newrelic/newrelic-telemetry-sdk-go/internal"
+)
+
+// Histogram is the metric type used for reporting the distribution of a
+// set of observed values, such as request latencies, without pre-computing
+// percentiles yourself. Observations are kept in a Reservoir so memory use
+// stays bounded regardless of how many values are observed in an interval,
+// and percentiles are computed from the reservoir's sample when the
+// Histogram is reported. Unlike Summary, a Histogram resets itself each
+// time it is reported; ResettingTimer is a shorthand that builds one with
+// the default reservoir.
+//
+// Example possible uses:
+//
+//  * the distribution of HTTP request latencies
+//  * the distribution of payload sizes
+//  * the distribution of queue depths sampled on every enqueue
+//
+type Histogram struct {
+	// Name is the name of this metric.
+	Name string
+	// Attributes is a map of attributes for this metric.
+	Attributes map[string]interface{}
+	// AttributesJSON is a json.RawMessage of attributes for this metric. It
+	// will only be sent if Attributes is nil.
+	AttributesJSON json.RawMessage
+	// Reservoir holds the sampled observations used to compute percentiles.
+	// If nil, NewUniformReservoir(1028) is used.
+	Reservoir Reservoir
+	// Timestamp is the start time of this metric's interval. If Timestamp
+	// is unset then the Harvester's period start will be used.
+	Timestamp time.Time
+	// Interval is the length of time for this metric. If Interval is unset
+	// then the time between Harvester harvests will be used.
+	Interval time.Duration
+
+	mu    sync.Mutex
+	count float64
+	sum   float64
+	min   float64
+	max   float64
+}
+
+// ResettingTimer returns a Histogram whose Reservoir and bookkeeping are
+// wiped on every report, so that each interval's percentiles describe only
+// the observations made during that interval.
+func ResettingTimer(name string, attributes map[string]interface{}) *Histogram {
+	return &Histogram{
+		Name:       name,
+		Attributes: attributes,
+		Reservoir:  NewUniformReservoir(1028),
+	}
+}
+
+// Observe records a single value, for example one request's latency in
+// milliseconds. It is safe to call Observe concurrently.
+func (h *Histogram) Observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.reservoir().update(value)
+	h.count++
+	h.sum += value
+	if h.count == 1 || value < h.min {
+		h.min = value
+	}
+	if h.count == 1 || value > h.max {
+		h.max = value
+	}
+}
+
+// reservoir returns h.Reservoir, lazily creating the default reservoir if
+// none was set. Callers must hold h.mu.
+func (h *Histogram) reservoir() Reservoir {
+	if h.Reservoir == nil {
+		h.Reservoir = NewUniformReservoir(1028)
+	}
+	return h.Reservoir
+}
+
+// GetName returns the Name of the Histogram
+func (h *Histogram) GetName() string {
+	return h.Name
+}
+
+// GetAttributes returns the Attributes of the Histogram
+func (h *Histogram) GetAttributes() map[string]interface{} {
+	return h.Attributes
+}
+
+// snapshot satisfies registeredMetric so a Histogram can be registered with
+// a MetricRegistry directly: it already resets itself on writeJSON, so the
+// Metric to report is the Histogram itself.
+func (h *Histogram) snapshot() Metric {
+	return h
+}
+
+func (h *Histogram) validate() map[string]interface{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := isFloatValid(h.sum); err != nil {
+		return map[string]interface{}{
+			"message": "invalid histogram field",
+			"name":    h.Name,
+			"err":     err.Error(),
+		}
+	}
+	for _, v := range []float64{h.min, h.max} {
+		if math.IsInf(v, 0) {
+			return map[string]interface{}{
+				"message": "invalid histogram field",
+				"name":    h.Name,
+				"err":     errFloatInfinity.Error(),
+			}
+		}
+	}
+	return nil
+}
+
+// stats returns the sum, count, min, max, mean, stddev, and sorted sample
+// held by the Histogram. Callers must hold h.mu.
+//
+// mean is exact, computed from the running sum/count of every Observe
+// call. stddev, like the percentiles, is a sample estimate computed from
+// the reservoir's (possibly subsampled) values; it is centered on the
+// sample's own mean rather than the exact mean so the two stay consistent
+// with each other when the reservoir has dropped observations.
+func (h *Histogram) stats() (sum, count, min, max, mean, stddev float64, sorted []float64) {
+	sorted = sortedCopy(h.reservoir().values())
+
+	if h.count == 0 {
+		return h.sum, h.count, math.NaN(), math.NaN(), 0, 0, sorted
+	}
+	mean = h.sum / h.count
+
+	if len(sorted) > 1 {
+		var sampleMean float64
+		for _, v := range sorted {
+			sampleMean += v
+		}
+		sampleMean /= float64(len(sorted))
+
+		var sumSquares float64
+		for _, v := range sorted {
+			d := v - sampleMean
+			sumSquares += d * d
+		}
+		stddev = math.Sqrt(sumSquares / (float64(len(sorted)) - 1))
+	}
+	return h.sum, h.count, h.min, h.max, mean, stddev, sorted
+}
+
+// consume returns the same values as stats, then resets the Histogram's
+// reservoir and bookkeeping so the next interval's observations are
+// reported on their own. Every Reporter must call consume, not stats,
+// when it reports a Histogram: reset-on-report is part of the Histogram's
+// contract, not something tied to any one Reporter's wire format.
+// Callers must hold h.mu.
+func (h *Histogram) consume() (sum, count, min, max, mean, stddev float64, sorted []float64) {
+	sum, count, min, max, mean, stddev, sorted = h.stats()
+	h.reservoir().reset()
+	h.count = 0
+	h.sum = 0
+	h.min = 0
+	h.max = 0
+	return sum, count, min, max, mean, stddev, sorted
+}
+
+// writePrometheus renders the Histogram's current state in Prometheus text
+// exposition format as a summary, without resetting it; only reporting it
+// (writeJSON or a Reporter that calls consume) consumes the interval's
+// observations.
+func (h *Histogram) writePrometheus(buf *strings.Builder, name string) {
+	h.mu.Lock()
+	sum, count, _, _, _, _, sorted := h.stats()
+	h.mu.Unlock()
+
+	labels := promLabels(h.Attributes)
+	fmt.Fprintf(buf, "# HELP %s %s\n", name, name)
+	fmt.Fprintf(buf, "# TYPE %s summary\n", name)
+	for _, q := range []float64{0.5, 0.95, 0.99} {
+		fmt.Fprintf(buf, "%s%s %s\n", name, promQuantileLabels(h.Attributes, q), promFloat(percentile(sorted, q)))
+	}
+	fmt.Fprintf(buf, "%s_sum%s %s\n", name, labels, promFloat(sum))
+	fmt.Fprintf(buf, "%s_count%s %s\n", name, labels, promFloat(count))
+}
+
+func (h *Histogram) writeJSON(buf *bytes.Buffer) {
+	h.mu.Lock()
+	sum, count, min, max, mean, stddev, sorted := h.consume()
+	attributes := make(map[string]interface{}, len(h.Attributes)+5)
+	if nil != h.Attributes {
+		for k, v := range h.Attributes {
+			attributes[k] = v
+		}
+	} else if nil != h.AttributesJSON {
+		// Percentiles must always be present, so a raw AttributesJSON
+		// blob is decoded and re-merged rather than passed through
+		// untouched like the other metric types do.
+		var decoded map[string]interface{}
+		if err := json.Unmarshal(h.AttributesJSON, &decoded); err == nil {
+			for k, v := range decoded {
+				attributes[k] = v
+			}
+		}
+	}
+	attributes["percentile.50"] = percentile(sorted, 0.50)
+	attributes["percentile.95"] = percentile(sorted, 0.95)
+	attributes["percentile.99"] = percentile(sorted, 0.99)
+	attributes["mean"] = mean
+	attributes["stddev"] = stddev
+	h.mu.Unlock()
+
+	w := internal.JSONFieldsWriter{Buf: buf}
+	buf.WriteByte('{')
+
+	w.StringField("name", h.Name)
+	w.StringField("type", "summary")
+
+	w.AddKey("value")
+	buf.WriteByte('{')
+	vw := internal.JSONFieldsWriter{Buf: buf}
+	vw.FloatField("sum", sum)
+	vw.FloatField("count", count)
+	if math.IsNaN(min) {
+		vw.RawField("min", json.RawMessage(`null`))
+	} else {
+		vw.FloatField("min", min)
+	}
+	if math.IsNaN(max) {
+		vw.RawField("max", json.RawMessage(`null`))
+	} else {
+		vw.FloatField("max", max)
+	}
+	buf.WriteByte('}')
+
+	writeTimestampInterval(&w, h.Timestamp, h.Interval)
+	w.WriterField("attributes", internal.Attributes(attributes))
+	buf.WriteByte('}')
+}
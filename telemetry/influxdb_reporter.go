@@ -0,0 +1,220 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InfluxDBReporter is a Reporter that serializes metrics to InfluxDB line
+// protocol and writes them to an InfluxDB v1 or v2 HTTP write endpoint on
+// the Harvester's usual cadence, letting the same instrumentation dual-ship
+// to a local TSDB instead of, or in addition to, New Relic.
+type InfluxDBReporter struct {
+	// WriteURL is the full InfluxDB write endpoint, for example
+	// "http://localhost:8086/write?db=mydb" for InfluxDB v1 or
+	// "http://localhost:8086/api/v2/write?org=o&bucket=b" for v2.
+	WriteURL string
+	// AuthHeader, if set, is sent as the HTTP Authorization header, e.g.
+	// "Token <v2-token>" for InfluxDB v2 or "Basic <base64 user:pass>" for
+	// v1.
+	AuthHeader string
+	// Client is used to perform the write. If nil, http.DefaultClient is
+	// used.
+	Client *http.Client
+}
+
+// Report writes metrics to the InfluxDB endpoint configured on r, one line
+// per metric.
+func (r *InfluxDBReporter) Report(ctx context.Context, metrics []Metric, commonAttributes map[string]interface{}) error {
+	buf := &bytes.Buffer{}
+	for _, m := range metrics {
+		writeInfluxLine(buf, m, commonAttributes)
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodPost, r.WriteURL, buf)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if r.AuthHeader != "" {
+		req.Header.Set("Authorization", r.AuthHeader)
+	}
+
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("influxdb write failed with status %s", resp.Status)
+	}
+	return nil
+}
+
+// writeInfluxLine appends one InfluxDB line-protocol line for m to buf,
+// merging commonAttributes and the metric's own attributes into tags. If m
+// is not one of the known Metric types, nothing is written: InfluxDB
+// rejects the entire write if any line has an empty field set, so a line
+// with no fields is worse than no line at all.
+func writeInfluxLine(buf *bytes.Buffer, m Metric, commonAttributes map[string]interface{}) {
+	line := &bytes.Buffer{}
+	if !writeInfluxFields(line, m) {
+		return
+	}
+
+	tags := make(map[string]interface{}, len(commonAttributes)+len(m.GetAttributes()))
+	for k, v := range commonAttributes {
+		tags[k] = v
+	}
+	for k, v := range m.GetAttributes() {
+		tags[k] = v
+	}
+
+	buf.WriteString(influxEscapeMeasurement(m.GetName()))
+	writeInfluxTags(buf, tags)
+	buf.WriteByte(' ')
+	buf.Write(line.Bytes())
+	buf.WriteByte(' ')
+	buf.WriteString(strconv.FormatInt(influxTimestamp(m), 10))
+	buf.WriteByte('\n')
+}
+
+func influxTimestamp(m Metric) int64 {
+	switch v := m.(type) {
+	case Count:
+		if !v.Timestamp.IsZero() {
+			return v.Timestamp.UnixNano()
+		}
+	case Gauge:
+		if !v.Timestamp.IsZero() {
+			return v.Timestamp.UnixNano()
+		}
+	case Summary:
+		if !v.Timestamp.IsZero() {
+			return v.Timestamp.UnixNano()
+		}
+	case *Histogram:
+		if !v.Timestamp.IsZero() {
+			return v.Timestamp.UnixNano()
+		}
+	case Info:
+		if !v.Timestamp.IsZero() {
+			return v.Timestamp.UnixNano()
+		}
+	}
+	return time.Now().UnixNano()
+}
+
+// writeInfluxFields writes m's fields to buf and reports whether anything
+// was written. m being of an unrecognized Metric type is the only case
+// that returns false; every known type always has at least one field.
+func writeInfluxFields(buf *bytes.Buffer, m Metric) bool {
+	switch v := m.(type) {
+	case Count:
+		fmt.Fprintf(buf, "value=%s", influxFloat(v.Value))
+	case Gauge:
+		fmt.Fprintf(buf, "value=%s", influxFloat(v.Value))
+	case Summary:
+		fmt.Fprintf(buf, "sum=%s,count=%s", influxFloat(v.Sum), influxFloat(v.Count))
+		writeInfluxMinMax(buf, v.Min, v.Max)
+	case *Histogram:
+		// consume both reads and resets the Histogram, the same
+		// reset-on-report contract Histogram.writeJSON honors for the NR
+		// wire format; without it a Harvester configured with only an
+		// InfluxDBReporter would never reset the Histogram and every
+		// interval would re-report its entire lifetime sample.
+		v.mu.Lock()
+		sum, count, min, max, _, _, sorted := v.consume()
+		v.mu.Unlock()
+		fmt.Fprintf(buf, "sum=%s,count=%s", influxFloat(sum), influxFloat(count))
+		writeInfluxMinMax(buf, min, max)
+		fmt.Fprintf(buf, ",p50=%s,p95=%s,p99=%s",
+			influxFloat(percentile(sorted, 0.50)),
+			influxFloat(percentile(sorted, 0.95)),
+			influxFloat(percentile(sorted, 0.99)))
+	case Info:
+		first := true
+		for k, val := range v.Value {
+			if !first {
+				buf.WriteByte(',')
+			}
+			first = false
+			fmt.Fprintf(buf, "%s=%q", influxEscapeKey(k), val)
+		}
+		if first {
+			buf.WriteString(`value=1`)
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// writeInfluxMinMax appends ,min=...,max=... fields for a Summary or
+// Histogram, omitting whichever of the two is NaN (the sentinel both
+// metric types use when no observations have been made). Line protocol
+// has no representation for NaN, so writing it literally would leave an
+// invalid field in the line, unlike the NR JSON encoding which has a
+// proper null for this case.
+func writeInfluxMinMax(buf *bytes.Buffer, min, max float64) {
+	if !math.IsNaN(min) {
+		fmt.Fprintf(buf, ",min=%s", influxFloat(min))
+	}
+	if !math.IsNaN(max) {
+		fmt.Fprintf(buf, ",max=%s", influxFloat(max))
+	}
+}
+
+func writeInfluxTags(buf *bytes.Buffer, tags map[string]interface{}) {
+	if len(tags) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		buf.WriteByte(',')
+		buf.WriteString(influxEscapeKey(k))
+		buf.WriteByte('=')
+		buf.WriteString(influxEscapeKey(fmt.Sprintf("%v", tags[k])))
+	}
+}
+
+// influxEscapeMeasurement escapes the characters InfluxDB line protocol
+// requires measurements to escape: comma, space, and newline.
+func influxEscapeMeasurement(s string) string {
+	r := strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	return r.Replace(s)
+}
+
+// influxEscapeKey escapes the characters InfluxDB line protocol requires
+// tag keys, tag values, and field keys to escape: comma, equals, space,
+// and newline.
+func influxEscapeKey(s string) string {
+	r := strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+	return r.Replace(s)
+}
+
+func influxFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,165 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/newrelic/newrelic-telemetry-sdk-go/internal"
+)
+
+// Info (also known as GaugeInfo) is the metric type used for reporting
+// static or slowly-changing facts rather than a numeric measurement, such
+// as a build SHA, Go version, hostname, region, or feature-flag state.
+// Value is emitted as extra attributes alongside a constant gauge value of
+// 1, the same "version gauge" pattern popularized by Prometheus, so that
+// these facts can be joined against other metrics without a separate event
+// type. Unlike Count, Gauge, and Summary, Info is not expected to be
+// recreated every interval; the Harvester skips re-sending an Info whose
+// fingerprint is unchanged since the last harvest.
+//
+// Example possible uses:
+//
+//  * the running binary's build SHA and Go version
+//  * the hostname, region, or datacenter a process is running in
+//  * which feature flags are currently enabled
+//
+type Info struct {
+	// Name is the name of this metric.
+	Name string
+	// Value holds the facts to report, for example
+	// {"version": "1.2.3", "go.version": "go1.21"}.
+	Value map[string]string
+	// Attributes is a map of attributes for this metric, reported
+	// alongside Value.
+	Attributes map[string]interface{}
+	// AttributesJSON is a json.RawMessage of attributes for this metric. It
+	// will only be sent if Attributes is nil.
+	AttributesJSON json.RawMessage
+	// Timestamp is the time this Info was gathered. If Timestamp is unset
+	// then the Harvester's period start will be used.
+	Timestamp time.Time
+}
+
+// GetName returns the Name of the Info
+func (i Info) GetName() string {
+	return i.Name
+}
+
+// GetAttributes returns the Attributes of the Info
+func (i Info) GetAttributes() map[string]interface{} {
+	return i.Attributes
+}
+
+func (i Info) validate() map[string]interface{} {
+	if len(i.Value) == 0 {
+		return map[string]interface{}{
+			"message": "invalid info value",
+			"name":    i.Name,
+			"err":     "Value must not be empty",
+		}
+	}
+	return nil
+}
+
+func (i Info) writeJSON(buf *bytes.Buffer) {
+	w := internal.JSONFieldsWriter{Buf: buf}
+	buf.WriteByte('{')
+	w.StringField("name", i.Name)
+	w.StringField("type", "gauge")
+	w.FloatField("value", 1)
+	writeTimestampInterval(&w, i.Timestamp, 0)
+
+	attributes := make(map[string]interface{}, len(i.Attributes)+len(i.Value))
+	if nil != i.Attributes {
+		for k, v := range i.Attributes {
+			attributes[k] = v
+		}
+	} else if nil != i.AttributesJSON {
+		// Value must always be merged in, so a raw AttributesJSON blob is
+		// decoded and re-merged rather than passed through untouched like
+		// the other metric types do.
+		var decoded map[string]interface{}
+		if err := json.Unmarshal(i.AttributesJSON, &decoded); err == nil {
+			for k, v := range decoded {
+				attributes[k] = v
+			}
+		}
+	}
+	for k, v := range i.Value {
+		attributes[k] = v
+	}
+	w.WriterField("attributes", internal.Attributes(attributes))
+	buf.WriteByte('}')
+}
+
+// fingerprint deterministically summarizes the payload of i, so a
+// Harvester can compare successive Infos with the same Name and skip
+// re-sending one whose Value and Attributes haven't changed.
+func (i Info) fingerprint() string {
+	keys := make([]string, 0, len(i.Value))
+	for k := range i.Value {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	h.Write([]byte(i.Name))
+	for _, k := range keys {
+		h.Write([]byte{0})
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		h.Write([]byte(i.Value[k]))
+	}
+	if nil != i.Attributes {
+		if js, err := json.Marshal(internal.Attributes(i.Attributes)); err == nil {
+			h.Write(js)
+		}
+	} else if nil != i.AttributesJSON {
+		h.Write(i.AttributesJSON)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// infoDedup tracks the most recently recorded fingerprint for each Info
+// name, so the Harvester can tell an unchanged Info apart from one whose
+// Value or Attributes actually changed since the last harvest.
+type infoDedup struct {
+	mu           sync.Mutex
+	fingerprints map[string]string
+}
+
+// changed reports whether i's fingerprint differs from the last one seen
+// for i.Name, recording the new fingerprint as a side effect.
+func (d *infoDedup) changed(i Info) bool {
+	fp := i.fingerprint()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.fingerprints == nil {
+		d.fingerprints = make(map[string]string)
+	}
+	if last, ok := d.fingerprints[i.Name]; ok && last == fp {
+		return false
+	}
+	d.fingerprints[i.Name] = fp
+	return true
+}
+
+// RecordInfo records i with h unless an Info with the same Name and an
+// identical fingerprint was already recorded by a previous harvest, so
+// unchanged build/version/feature-flag facts aren't re-shipped every
+// interval the way a Count or Gauge would be.
+func (h *Harvester) RecordInfo(i Info) {
+	if !h.infoDedup.changed(i) {
+		return
+	}
+	h.RecordMetric(i)
+}
@@ -63,7 +63,7 @@ func (c Count) validate() map[string]interface{} {
 	return nil
 }
 
-// Metric is implemented by Count, Gauge, and Summary.
+// Metric is implemented by Count, Gauge, Summary, Histogram, and Info.
 type Metric interface {
 	DataType
 	writeJSON(buf *bytes.Buffer)
@@ -0,0 +1,128 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PrometheusHandler returns an http.Handler that renders the current state
+// of r in the Prometheus text exposition format, so the same instruments
+// registered with NewCounter/NewGauge/NewSummary/NewHistogram can be
+// scraped by Prometheus, VictoriaMetrics, or any other OpenMetrics
+// collector in addition to being harvested to New Relic. Unlike Collect,
+// this does not reset or otherwise consume any instrument's state.
+func (r *MetricRegistry) PrometheusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+		r.mu.Lock()
+		names := make([]string, 0, len(r.metrics))
+		for name := range r.metrics {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		metrics := make([]registeredMetric, len(names))
+		for i, name := range names {
+			metrics[i] = r.metrics[name]
+		}
+		r.mu.Unlock()
+
+		buf := &strings.Builder{}
+		for i, m := range metrics {
+			writePrometheusMetric(buf, promSanitizeName(names[i]), m)
+		}
+		fmt.Fprint(w, buf.String())
+	})
+}
+
+// promMetric is implemented by every registeredMetric that can render
+// itself in Prometheus text exposition format.
+type promMetric interface {
+	writePrometheus(buf *strings.Builder, name string)
+}
+
+func writePrometheusMetric(buf *strings.Builder, name string, m registeredMetric) {
+	if pm, ok := m.(promMetric); ok {
+		pm.writePrometheus(buf, name)
+	}
+}
+
+// promSanitizeName rewrites name so it is a valid Prometheus metric or
+// label name: '.' becomes '_' (New Relic's dotted naming convention is
+// common for metric and attribute names) and any other disallowed
+// character is stripped outright.
+func promSanitizeName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r == '.':
+			b.WriteByte('_')
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if b.Len() == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// promLabels renders attrs as a Prometheus label list, e.g. `{a="1",b="2"}`,
+// or the empty string if attrs is empty. Keys are sanitized with
+// promSanitizeName and sorted for deterministic output.
+func promLabels(attrs map[string]interface{}) string {
+	if len(attrs) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(promSanitizeName(k))
+		b.WriteString(`="`)
+		b.WriteString(promEscapeValue(fmt.Sprintf("%v", attrs[k])))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// promEscapeValue escapes a label value per the Prometheus exposition
+// format: backslash, double quote, and newline must be escaped.
+func promEscapeValue(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `"`, `\"`)
+	v = strings.ReplaceAll(v, "\n", `\n`)
+	return v
+}
+
+func promFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// promQuantileLabels renders attrs plus a `quantile` label for a summary
+// line, e.g. `{a="1",quantile="0.95"}`.
+func promQuantileLabels(attrs map[string]interface{}, q float64) string {
+	merged := make(map[string]interface{}, len(attrs)+1)
+	for k, v := range attrs {
+		merged[k] = v
+	}
+	merged["quantile"] = promFloat(q)
+	return promLabels(merged)
+}
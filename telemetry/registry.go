@@ -0,0 +1,278 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errMetricTypeMismatch is returned by MetricRegistry's New* methods when
+// name was already registered as a different instrument type, e.g.
+// NewGauge("x", nil) after a prior NewCounter("x", nil).
+func errMetricTypeMismatch(name string, want string, got registeredMetric) error {
+	return fmt.Errorf("telemetry: %q is already registered as a %T, not a %s", name, got, want)
+}
+
+// MetricRegistry holds a set of live, concurrency-safe metric instruments
+// that are registered once and updated in place, following the registry
+// model popularized by go-metrics. Code on a hot path calls Inc/Add/Set/
+// Update/Observe on the handle it was given at registration time instead of
+// allocating a new Count/Gauge/Summary/Histogram value per data point. At
+// harvest time the Harvester walks the registry, snapshots each instrument
+// into the Metric it represents (resetting cumulative instruments as it
+// goes), and includes the snapshots in the metricBatch.
+type MetricRegistry struct {
+	mu      sync.Mutex
+	metrics map[string]registeredMetric
+}
+
+// NewMetricRegistry creates an empty MetricRegistry.
+func NewMetricRegistry() *MetricRegistry {
+	return &MetricRegistry{
+		metrics: make(map[string]registeredMetric),
+	}
+}
+
+// registeredMetric is implemented by every live instrument a MetricRegistry
+// can hold.
+type registeredMetric interface {
+	// snapshot returns the Metric to include in the next harvest, resetting
+	// any interval-scoped state the instrument tracks.
+	snapshot() Metric
+}
+
+// register returns the existing instrument for name if one was already
+// created, otherwise it stores and returns newMetric().
+func (r *MetricRegistry) register(name string, newMetric func() registeredMetric) registeredMetric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.metrics[name]; ok {
+		return existing
+	}
+	m := newMetric()
+	r.metrics[name] = m
+	return m
+}
+
+// Counter is a concurrency-safe handle to a registered Count instrument.
+// Its cumulative total is tracked so it can be scraped as a Prometheus
+// counter; each harvest reports only the delta since the previous harvest.
+type Counter struct {
+	name       string
+	attributes map[string]interface{}
+
+	mu       sync.Mutex
+	total    float64
+	reported float64
+}
+
+// NewCounter registers (or returns the already-registered) Counter with the
+// given name and attributes. It returns an error if name is already
+// registered as a different instrument type.
+func (r *MetricRegistry) NewCounter(name string, attributes map[string]interface{}) (*Counter, error) {
+	m := r.register(name, func() registeredMetric {
+		return &Counter{name: name, attributes: attributes}
+	})
+	c, ok := m.(*Counter)
+	if !ok {
+		return nil, errMetricTypeMismatch(name, "Counter", m)
+	}
+	return c, nil
+}
+
+// Inc increments the counter by 1.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add increments the counter by delta, which must be non-negative.
+func (c *Counter) Add(delta float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.total += delta
+}
+
+func (c *Counter) snapshot() Metric {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delta := c.total - c.reported
+	c.reported = c.total
+	return Count{Name: c.name, Attributes: c.attributes, Value: delta}
+}
+
+func (c *Counter) writePrometheus(buf *strings.Builder, name string) {
+	c.mu.Lock()
+	total := c.total
+	c.mu.Unlock()
+
+	fmt.Fprintf(buf, "# HELP %s %s\n", name, name)
+	fmt.Fprintf(buf, "# TYPE %s counter\n", name)
+	fmt.Fprintf(buf, "%s%s %s\n", name, promLabels(c.attributes), promFloat(total))
+}
+
+// GaugeHandle is a concurrency-safe handle to a registered Gauge
+// instrument.
+type GaugeHandle struct {
+	name       string
+	attributes map[string]interface{}
+
+	mu    sync.Mutex
+	value float64
+}
+
+// NewGauge registers (or returns the already-registered) GaugeHandle with
+// the given name and attributes. It returns an error if name is already
+// registered as a different instrument type.
+func (r *MetricRegistry) NewGauge(name string, attributes map[string]interface{}) (*GaugeHandle, error) {
+	m := r.register(name, func() registeredMetric {
+		return &GaugeHandle{name: name, attributes: attributes}
+	})
+	g, ok := m.(*GaugeHandle)
+	if !ok {
+		return nil, errMetricTypeMismatch(name, "GaugeHandle", m)
+	}
+	return g, nil
+}
+
+// Set records the current value of the gauge.
+func (g *GaugeHandle) Set(value float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.value = value
+}
+
+func (g *GaugeHandle) snapshot() Metric {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return Gauge{Name: g.name, Attributes: g.attributes, Value: g.value, Timestamp: time.Now()}
+}
+
+func (g *GaugeHandle) writePrometheus(buf *strings.Builder, name string) {
+	g.mu.Lock()
+	value := g.value
+	g.mu.Unlock()
+
+	fmt.Fprintf(buf, "# HELP %s %s\n", name, name)
+	fmt.Fprintf(buf, "# TYPE %s gauge\n", name)
+	fmt.Fprintf(buf, "%s%s %s\n", name, promLabels(g.attributes), promFloat(value))
+}
+
+// SummaryHandle is a concurrency-safe handle to a registered Summary
+// instrument.
+type SummaryHandle struct {
+	name       string
+	attributes map[string]interface{}
+
+	mu    sync.Mutex
+	count float64
+	sum   float64
+	min   float64
+	max   float64
+}
+
+// NewSummary registers (or returns the already-registered) SummaryHandle
+// with the given name and attributes. It returns an error if name is
+// already registered as a different instrument type.
+func (r *MetricRegistry) NewSummary(name string, attributes map[string]interface{}) (*SummaryHandle, error) {
+	m := r.register(name, func() registeredMetric {
+		return &SummaryHandle{name: name, attributes: attributes}
+	})
+	s, ok := m.(*SummaryHandle)
+	if !ok {
+		return nil, errMetricTypeMismatch(name, "SummaryHandle", m)
+	}
+	return s, nil
+}
+
+// Update records a single observation, for example the duration of a span.
+func (s *SummaryHandle) Update(value float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.count == 0 || value < s.min {
+		s.min = value
+	}
+	if s.count == 0 || value > s.max {
+		s.max = value
+	}
+	s.count++
+	s.sum += value
+}
+
+func (s *SummaryHandle) snapshot() Metric {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := Summary{
+		Name:       s.name,
+		Attributes: s.attributes,
+		Count:      s.count,
+		Sum:        s.sum,
+		Min:        s.min,
+		Max:        s.max,
+	}
+	s.count, s.sum, s.min, s.max = 0, 0, 0, 0
+	return out
+}
+
+func (s *SummaryHandle) writePrometheus(buf *strings.Builder, name string) {
+	s.mu.Lock()
+	count, sum, min, max := s.count, s.sum, s.min, s.max
+	s.mu.Unlock()
+
+	labels := promLabels(s.attributes)
+	fmt.Fprintf(buf, "# HELP %s %s\n", name, name)
+	fmt.Fprintf(buf, "# TYPE %s summary\n", name)
+	fmt.Fprintf(buf, "%s_sum%s %s\n", name, labels, promFloat(sum))
+	fmt.Fprintf(buf, "%s_count%s %s\n", name, labels, promFloat(count))
+	fmt.Fprintf(buf, "%s_min%s %s\n", name, labels, promFloat(min))
+	fmt.Fprintf(buf, "%s_max%s %s\n", name, labels, promFloat(max))
+}
+
+// NewHistogram registers (or returns the already-registered) Histogram
+// with the given name, attributes, and Reservoir. reservoir is only used
+// the first time name is registered. A nil reservoir falls back to
+// NewUniformReservoir(1028), the same default Histogram uses on its own.
+// It returns an error if name is already registered as a different
+// instrument type.
+func (r *MetricRegistry) NewHistogram(name string, attributes map[string]interface{}, reservoir Reservoir) (*Histogram, error) {
+	m := r.register(name, func() registeredMetric {
+		return &Histogram{Name: name, Attributes: attributes, Reservoir: reservoir}
+	})
+	h, ok := m.(*Histogram)
+	if !ok {
+		return nil, errMetricTypeMismatch(name, "Histogram", m)
+	}
+	return h, nil
+}
+
+// Collect snapshots every registered instrument into the Metric it
+// represents, resetting Counter and Summary instruments in the process. A
+// Histogram is returned as itself and is reset when it is reported rather
+// than here. The Harvester calls this once per harvest.
+func (r *MetricRegistry) Collect() []Metric {
+	r.mu.Lock()
+	snapshot := make([]registeredMetric, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		snapshot = append(snapshot, m)
+	}
+	r.mu.Unlock()
+
+	metrics := make([]Metric, len(snapshot))
+	for i, m := range snapshot {
+		metrics[i] = m.snapshot()
+	}
+	return metrics
+}
+
+// Harvest collects every registered instrument and records the resulting
+// Metrics with h, so a MetricRegistry only needs to be wired into a
+// Harvester once instead of copying each instrument's value by hand on
+// every interval.
+func (r *MetricRegistry) Harvest(h *Harvester) {
+	for _, m := range r.Collect() {
+		h.RecordMetric(m)
+	}
+}
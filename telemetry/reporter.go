@@ -0,0 +1,65 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/newrelic/newrelic-telemetry-sdk-go/internal"
+)
+
+// Reporter delivers one harvest interval's worth of metrics somewhere.
+// The default Reporter used by NewHarvester posts them to New Relic's
+// metric API; ConfigReporter installs a different (or additional, via a
+// Reporter that fans out to several backends) Reporter so the same
+// instrumentation can be dual-shipped without any change to how metrics
+// are recorded.
+type Reporter interface {
+	// Report delivers metrics gathered over a single harvest interval,
+	// along with commonAttributes for this call, to the Reporter's
+	// backend. ctx is the harvest's context and should be honored for
+	// cancellation. A Reporter that is bound to a Harvester (such as the
+	// default NR reporter) merges these with the Harvester's own
+	// CommonAttributes; a standalone Reporter (such as InfluxDBReporter)
+	// treats commonAttributes as the complete set.
+	Report(ctx context.Context, metrics []Metric, commonAttributes map[string]interface{}) error
+}
+
+// ConfigReporter overrides the Harvester's default Reporter, which
+// otherwise posts metrics to New Relic.
+func ConfigReporter(reporter Reporter) HarvestOption {
+	return func(h *Harvester) {
+		h.reporter = reporter
+	}
+}
+
+// nrReporter is the Harvester's default Reporter. It builds the same
+// metricBatch wire format the Harvester sent before this Reporter
+// abstraction was introduced, and delivers it over the Harvester's
+// existing New Relic request pipeline.
+type nrReporter struct {
+	harvester *Harvester
+}
+
+func (r *nrReporter) Report(ctx context.Context, metrics []Metric, commonAttributes map[string]interface{}) error {
+	attrs := r.harvester.CommonAttributes()
+	merged := make(map[string]interface{}, len(attrs)+len(commonAttributes))
+	for k, v := range attrs {
+		merged[k] = v
+	}
+	for k, v := range commonAttributes {
+		merged[k] = v
+	}
+
+	batch := &metricBatch{Metrics: metrics}
+	if len(merged) > 0 {
+		js, err := json.Marshal(internal.Attributes(merged))
+		if err != nil {
+			return err
+		}
+		batch.AttributesJSON = js
+	}
+	return r.harvester.deliver(ctx, requestsBuilder(batch))
+}
@@ -0,0 +1,215 @@
+// Copyright 2019 New Relic Corporation. All rights reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"container/heap"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Reservoir is a fixed-size sample of observed values used to estimate
+// percentiles for a Histogram without retaining every observation. Use
+// NewUniformReservoir for a statistically uniform sample of the whole
+// stream, or NewExpDecayReservoir to bias the sample towards recently
+// observed values.
+type Reservoir interface {
+	// update records a newly observed value.
+	update(value float64)
+	// values returns a copy of the values currently held by the reservoir.
+	// The returned slice is not sorted.
+	values() []float64
+	// reset clears the reservoir so the next interval starts empty.
+	reset()
+}
+
+// percentile returns the linearly interpolated value at quantile q (0-1)
+// from a slice that has already been sorted in ascending order.
+func percentile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	if len(sorted) == 1 {
+		return sorted[0]
+	}
+	rank := q * float64(len(sorted)-1)
+	lo := int(math.Floor(rank))
+	hi := int(math.Ceil(rank))
+	if lo == hi {
+		return sorted[lo]
+	}
+	frac := rank - float64(lo)
+	return sorted[lo]*(1-frac) + sorted[hi]*frac
+}
+
+// sortedCopy returns values sorted in ascending order without mutating the
+// input slice.
+func sortedCopy(values []float64) []float64 {
+	out := make([]float64, len(values))
+	copy(out, values)
+	sort.Float64s(out)
+	return out
+}
+
+// uniformReservoir is a Reservoir implementing Vitter's Algorithm R: the
+// first `size` observations are kept outright, and each subsequent
+// observation n (n > size) replaces a uniformly random existing slot with
+// probability size/n. This yields a sample that is statistically uniform
+// over the entire stream regardless of its length.
+type uniformReservoir struct {
+	mu     sync.Mutex
+	size   int
+	count  int64
+	sample []float64
+}
+
+// NewUniformReservoir returns a Reservoir that keeps a uniformly random
+// sample of up to size observations, suitable for percentiles that weight
+// the whole reporting interval equally.
+func NewUniformReservoir(size int) Reservoir {
+	return &uniformReservoir{size: size}
+}
+
+func (r *uniformReservoir) update(v float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.count++
+	if len(r.sample) < r.size {
+		r.sample = append(r.sample, v)
+		return
+	}
+	j := rand.Int63n(r.count)
+	if j < int64(r.size) {
+		r.sample[j] = v
+	}
+}
+
+func (r *uniformReservoir) values() []float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]float64, len(r.sample))
+	copy(out, r.sample)
+	return out
+}
+
+func (r *uniformReservoir) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sample = nil
+	r.count = 0
+}
+
+// decaySample is a single entry kept by an expDecayReservoir.
+type decaySample struct {
+	priority float64
+	value    float64
+}
+
+// decayHeap is a min-heap of decaySample ordered by priority, so the
+// lowest-priority (most aggressively decayed) sample is evicted first.
+type decayHeap []decaySample
+
+func (h decayHeap) Len() int            { return len(h) }
+func (h decayHeap) Less(i, j int) bool  { return h[i].priority < h[j].priority }
+func (h decayHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *decayHeap) Push(x interface{}) { *h = append(*h, x.(decaySample)) }
+func (h *decayHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+// expDecayRescaleInterval is how often priorities are rescaled to keep
+// w(t) from overflowing float64 range on long-lived reservoirs.
+const expDecayRescaleInterval = time.Hour
+
+// expDecayReservoir is a Reservoir implementing the Cormode/Shahabi
+// forward-decaying exponential reservoir. Each sample is stored with
+// priority = w(t)/u where u is drawn uniformly from (0,1] and
+// w(t) = exp(alpha*(t-t0)), so recent observations are exponentially more
+// likely to survive eviction than old ones. This biases percentiles
+// towards recent behaviour, which is useful for long harvest intervals.
+type expDecayReservoir struct {
+	mu          sync.Mutex
+	alpha       float64
+	size        int
+	startTime   time.Time
+	nextRescale time.Time
+	heap        decayHeap
+}
+
+// NewExpDecayReservoir returns a Reservoir that keeps up to size samples,
+// weighted by alpha so that more recent observations are more likely to
+// be retained. A larger alpha decays older samples faster; go-metrics'
+// default of 0.015 is a reasonable starting point for per-minute harvests.
+func NewExpDecayReservoir(size int, alpha float64) Reservoir {
+	now := time.Now()
+	return &expDecayReservoir{
+		alpha:       alpha,
+		size:        size,
+		startTime:   now,
+		nextRescale: now.Add(expDecayRescaleInterval),
+	}
+}
+
+func (r *expDecayReservoir) weight(t time.Time) float64 {
+	return math.Exp(r.alpha * t.Sub(r.startTime).Seconds())
+}
+
+func (r *expDecayReservoir) update(v float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	priority := r.weight(now) / (1 - rand.Float64())
+	sample := decaySample{priority: priority, value: v}
+
+	if len(r.heap) < r.size {
+		heap.Push(&r.heap, sample)
+	} else if len(r.heap) > 0 && priority > r.heap[0].priority {
+		heap.Pop(&r.heap)
+		heap.Push(&r.heap, sample)
+	}
+
+	if now.After(r.nextRescale) {
+		r.rescale(now)
+	}
+}
+
+// rescale divides every stored priority by w(t_new - t_old), re-basing
+// w(t) at the new start time so priorities keep shrinking relative to
+// `now` instead of growing without bound.
+func (r *expDecayReservoir) rescale(now time.Time) {
+	oldStart := r.startTime
+	r.startTime = now
+	r.nextRescale = now.Add(expDecayRescaleInterval)
+	factor := math.Exp(-r.alpha * now.Sub(oldStart).Seconds())
+	for i := range r.heap {
+		r.heap[i].priority *= factor
+	}
+	heap.Init(&r.heap)
+}
+
+func (r *expDecayReservoir) values() []float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]float64, len(r.heap))
+	for i, s := range r.heap {
+		out[i] = s.value
+	}
+	return out
+}
+
+func (r *expDecayReservoir) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.heap = nil
+	r.startTime = time.Now()
+	r.nextRescale = r.startTime.Add(expDecayRescaleInterval)
+}